@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+
+	"github.com/goat-project/goat-one/constants"
+	"github.com/goat-project/goat-one/resource/virtualmachine"
+	"github.com/goat-project/goat-one/writer/spool"
+
+	pb "github.com/goat-project/goat-proto-go"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// replayCmd drains whatever is left in the on-disk spool into the Goat
+// server, for when a previous run was killed before it could finish.
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay unacknowledged records from the local spool to the Goat server",
+	Run: func(cmd *cobra.Command, args []string) {
+		runReplay()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+}
+
+func runReplay() {
+	if !viper.GetBool(constants.CfgSpoolEnabled) {
+		log.Warn("spool is disabled in configuration, nothing to replay")
+		return
+	}
+
+	grpcWriter := virtualmachine.CreateWriter(rate.NewLimiter(rate.Inf, 0))
+
+	sw := spool.CreateWriter(grpcWriter, func() proto.Message { return &pb.VmRecord{} })
+	sw.Finish()
+}
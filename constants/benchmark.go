@@ -0,0 +1,11 @@
+package constants
+
+const (
+	// CfgBenchmarkDefaultType is the benchmark type applied when no host,
+	// cluster, or datastore in the fallback chain defines one.
+	CfgBenchmarkDefaultType = "benchmark.default_type"
+
+	// CfgBenchmarkDefaultValue is the benchmark value applied when no host,
+	// cluster, or datastore in the fallback chain defines one.
+	CfgBenchmarkDefaultValue = "benchmark.default_value"
+)
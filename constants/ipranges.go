@@ -0,0 +1,8 @@
+package constants
+
+const (
+	// CfgAdditionalPrivateRanges holds extra CIDR ranges (IPv4 or IPv6) that
+	// a site wants excluded from the public IP count on top of the IANA
+	// special-purpose registries already built in.
+	CfgAdditionalPrivateRanges = "ip.additional_private_ranges"
+)
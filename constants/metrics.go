@@ -0,0 +1,20 @@
+package constants
+
+const (
+	// CfgWriterBackend selects which sink Preparer.Writer is built from:
+	// "grpc" (default, the Goat/APEL gRPC sink) or "metrics" (Prometheus/
+	// Ceilometer-style, see CfgMetricsMode).
+	CfgWriterBackend = "writer.backend"
+
+	// CfgMetricsMode selects the metrics backend behaviour: "pull" (default,
+	// expose a Prometheus scrape endpoint) or "push" (Ceilometer-style, send
+	// samples to a collector).
+	CfgMetricsMode = "metrics.mode"
+
+	// CfgMetricsListenAddress is the address the Prometheus endpoint listens
+	// on in pull mode, e.g. ":9195".
+	CfgMetricsListenAddress = "metrics.listen_address"
+
+	// CfgMetricsPushURL is the collector URL samples are posted to in push mode.
+	CfgMetricsPushURL = "metrics.push_url"
+)
@@ -0,0 +1,19 @@
+package constants
+
+const (
+	// CfgSpoolEnabled turns on the durable on-disk spool in front of the
+	// gRPC writer so records survive a Goat server outage.
+	CfgSpoolEnabled = "spool.enabled"
+
+	// CfgSpoolPath is the directory the spool persists unacknowledged
+	// records to.
+	CfgSpoolPath = "spool.path"
+
+	// CfgSpoolMaxSize is the maximum combined size, in bytes, the spool is
+	// allowed to grow to before new writes start failing.
+	CfgSpoolMaxSize = "spool.max_size"
+
+	// CfgSpoolRetention is how long an unacknowledged entry is kept before
+	// it is discarded.
+	CfgSpoolRetention = "spool.retention"
+)
@@ -0,0 +1,17 @@
+package constants
+
+const (
+	// CfgStorageDatastoreTypes restricts storage accounting to the listed
+	// datastore types (e.g. "SYSTEM", "IMAGE", "FILE"). Empty means every
+	// datastore type is accounted for.
+	CfgStorageDatastoreTypes = "storage.datastore_types"
+
+	// CfgStorageSystem is the storage system name reported on every
+	// StorageRecord (e.g. the storage backend product name).
+	CfgStorageSystem = "storage.system"
+
+	// CfgStorageReportStart overrides the start of the StorageRecord
+	// reporting window. When unset, the window starts at the beginning of
+	// the current calendar month (UTC).
+	CfgStorageReportStart = "storage.report_start"
+)
@@ -0,0 +1,7 @@
+package constants
+
+const (
+	// CfgGoatServerAddress is the address (host:port) of the Goat server gRPC
+	// endpoint records are written to.
+	CfgGoatServerAddress = "writer.goat_server_address"
+)
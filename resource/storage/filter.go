@@ -3,21 +3,65 @@ package storage
 import (
 	"sync"
 
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
 	"github.com/goat-project/goat-one/resource"
+
+	"github.com/onego-project/onego/resources"
+
+	log "github.com/sirupsen/logrus"
 )
 
-// Filter to filter storage data.
+// Filter to filter storage data by datastore type (SYSTEM/IMAGE/FILE).
 type Filter struct {
+	allowedTypes map[string]bool
 }
 
-// CreateFilter creates Filter.
+// CreateFilter creates Filter, reading the allowed datastore types from
+// viper configuration (constants.CfgStorageDatastoreTypes). An empty
+// configuration allows every datastore type through.
 func CreateFilter() *Filter {
-	return &Filter{}
+	types := viper.GetStringSlice(constants.CfgStorageDatastoreTypes)
+
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[t] = true
+	}
+
+	return &Filter{allowedTypes: allowed}
 }
 
-// Filtering - only for VM relevant.
+// Filtering only lets a datastore through if its type is one of the
+// configured allowed datastore types (or every type is allowed, when none
+// were configured); other datastores are dropped instead of forwarded.
+// Resources that are not datastores are forwarded unchanged.
 func (f *Filter) Filtering(res resource.Resource, filtered chan resource.Resource, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	datastore, ok := res.(*resources.Datastore)
+	if !ok {
+		filtered <- res
+		return
+	}
+
+	if !f.allows(datastore) {
+		return
+	}
+
 	filtered <- res
 }
+
+func (f *Filter) allows(datastore *resources.Datastore) bool {
+	if len(f.allowedTypes) == 0 {
+		return true
+	}
+
+	dsType, err := datastore.Attribute("TYPE")
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error get datastore type, unable to filter")
+		return false
+	}
+
+	return f.allowedTypes[dsType]
+}
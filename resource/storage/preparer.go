@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
+	"github.com/goat-project/goat-one/reader"
+	"github.com/goat-project/goat-one/resource"
+	"github.com/goat-project/goat-one/writer"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	"github.com/onego-project/onego/resources"
+
+	pb "github.com/goat-project/goat-proto-go"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Preparer to prepare storage data (datastore/image usage) to specific
+// structure for writing to Goat server.
+type Preparer struct {
+	reader   reader.Reader
+	Writer   writer.Writer
+	registry *Registry
+
+	imagesByDatastore map[int][]*resources.Image
+}
+
+// CreatePreparer creates Preparer for storage records.
+func CreatePreparer(reader *reader.Reader) *Preparer {
+	return &Preparer{
+		reader:   *reader,
+		Writer:   *writer.CreateWriter(CreateWriter()),
+		registry: NewRegistry(),
+	}
+}
+
+// Registry returns the correlation registry this Preparer populates as it
+// runs. Hand it to virtualmachine.CreatePreparer so pb.VmRecord.StorageRecordId
+// can be resolved once the storage pass completes - this is the explicit
+// handle that replaces reaching into a package global.
+func (p *Preparer) Registry() *Registry {
+	return p.registry
+}
+
+// InitializeMaps lists every image once and groups it by datastore, so
+// Preparation doesn't re-list every image in the cloud for every datastore it
+// prepares.
+func (p *Preparer) InitializeMaps(mapWg *sync.WaitGroup) {
+	defer mapWg.Done()
+
+	images, err := p.reader.ListAllImages()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error list all images")
+		return
+	}
+
+	p.imagesByDatastore = make(map[int][]*resources.Image)
+
+	for _, image := range images {
+		if image == nil {
+			continue
+		}
+
+		dsID, err := image.Datastore()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("error get image datastore")
+			continue
+		}
+
+		p.imagesByDatastore[dsID] = append(p.imagesByDatastore[dsID], image)
+	}
+}
+
+// Preparation prepares a datastore's storage data for writing, emits the
+// APEL StorageRecord, and registers it in the correlation registry so
+// virtualmachine.Preparer can reference it from pb.VmRecord.StorageRecordId.
+func (p *Preparer) Preparation(acc resource.Resource, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	datastore, ok := acc.(*resources.Datastore)
+	if datastore == nil || !ok {
+		log.Error("error prepare empty datastore")
+		return
+	}
+
+	id, err := datastore.ID()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error prepare datastore, unable to get ID")
+		return
+	}
+
+	recordID := fmt.Sprintf("%s:datastore:%d", getSiteName(), id)
+	images := p.imagesByDatastore[id]
+
+	fileCount, capacityUsed := imagesUsage(images)
+	startTime, endTime := reportingWindow()
+
+	storageRecord := pb.StorageRecord{
+		RecordId:             recordID,
+		StorageSystem:        viper.GetString(constants.CfgStorageSystem),
+		SiteName:             getSiteName(),
+		StorageShare:         checkValueErrStr(fmt.Sprint(id), nil),
+		StorageMedia:         storageMedia(datastore),
+		FileCount:            &wrappers.UInt64Value{Value: fileCount},
+		ResourceCapacityUsed: capacityUsed,
+		StartTime:            startTime,
+		EndTime:              endTime,
+	}
+
+	if err := p.Writer.Write(&storageRecord); err != nil {
+		log.WithFields(log.Fields{"error": err, "datastore": id}).Error("error write storage record")
+		return
+	}
+
+	p.registry.register(images, recordID)
+}
+
+// SendIdentifier sends identifier to Goat server.
+func (p *Preparer) SendIdentifier() error {
+	return p.Writer.SendIdentifier()
+}
+
+// Finish gets to know to the Goat server that a writing is finished and a
+// response is expected. Then, it closes the gRPC connection. It also marks
+// this Preparer's Registry as complete - the run pipeline must call this
+// before starting the virtualmachine pass so pb.VmRecord.StorageRecordId can
+// be trusted.
+func (p *Preparer) Finish() {
+	p.Writer.Finish()
+	p.registry.markComplete()
+}
+
+func imagesUsage(images []*resources.Image) (fileCount uint64, capacityUsed uint64) {
+	for _, image := range images {
+		if image == nil {
+			continue
+		}
+
+		fileCount++
+
+		size, err := image.Attribute("SIZE")
+		if err != nil {
+			continue
+		}
+
+		capacityUsed += parseSize(size)
+	}
+
+	return fileCount, capacityUsed
+}
+
+func storageMedia(datastore *resources.Datastore) *wrappers.StringValue {
+	media, err := datastore.Attribute("TM_MAD")
+	if err != nil {
+		return nil
+	}
+
+	return &wrappers.StringValue{Value: media}
+}
+
+// reportingWindow returns the StorageRecord accounting period: the
+// configured CfgStorageReportStart (or the start of the current calendar
+// month, UTC, if unset) through now.
+func reportingWindow() (start, end *timestamp.Timestamp) {
+	from := viper.GetTime(constants.CfgStorageReportStart)
+	if from.IsZero() {
+		now := time.Now().UTC()
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	startTS, err := ptypes.TimestampProto(from)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error convert storage report start time")
+	}
+
+	endTS, err := ptypes.TimestampProto(time.Now().UTC())
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error convert storage report end time")
+	}
+
+	return startTS, endTS
+}
+
+func getSiteName() string {
+	siteName := viper.GetString(constants.CfgSiteName)
+	if siteName == "" {
+		log.Error("no site name in configuration") // should never happen
+	}
+
+	return siteName
+}
+
+func checkValueErrStr(value string, err error) *wrappers.StringValue {
+	if err == nil && value != "" {
+		return &wrappers.StringValue{Value: value}
+	}
+
+	return nil
+}
+
+func parseSize(value string) uint64 {
+	var size uint64
+
+	if _, err := fmt.Sscanf(value, "%d", &size); err != nil {
+		return 0
+	}
+
+	return size
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	"github.com/onego-project/onego/resources"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Registry correlates an image with the StorageRecord produced for the
+// datastore it lives on, so virtualmachine.Preparer can populate
+// pb.VmRecord.StorageRecordId for a VM's disks.
+//
+// A Registry is owned by a single storage.Preparer (see Preparer.Registry)
+// and must be handed to virtualmachine.CreatePreparer explicitly rather than
+// reached for through a package global, so the storage-before-virtualmachine
+// ordering dependency is structural: ImageLookup blocks until the storage
+// pass has called Preparer.Finish, so it can never observe a
+// partially-populated registry.
+type Registry struct {
+	mu      sync.RWMutex
+	records map[int]string
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewRegistry creates an empty Registry for a storage.Preparer to populate.
+func NewRegistry() *Registry {
+	return &Registry{
+		records: make(map[int]string),
+		done:    make(chan struct{}),
+	}
+}
+
+// register associates every image on a datastore (already grouped by the
+// caller, see Preparer.imagesByDatastore) with the StorageRecord produced
+// for it.
+func (r *Registry) register(images []*resources.Image, recordID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, image := range images {
+		if image == nil {
+			continue
+		}
+
+		id, err := image.ID()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("error get image ID, unable to correlate storage record")
+			continue
+		}
+
+		r.records[id] = recordID
+	}
+}
+
+// markComplete signals that the storage pass has registered every
+// datastore's images, unblocking any pending or future ImageLookup calls.
+func (r *Registry) markComplete() {
+	r.doneOnce.Do(func() { close(r.done) })
+}
+
+// ImageLookup returns the StorageRecord produced for the datastore imageID
+// lives on, or nil if imageID isn't on any known datastore. It blocks until
+// the storage pass has finished (see the Registry doc comment above), so the
+// caller never has to guess whether the registry is safe to trust yet.
+func (r *Registry) ImageLookup(imageID int) *wrappers.StringValue {
+	<-r.done
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	recordID, ok := r.records[imageID]
+	if !ok {
+		return nil
+	}
+
+	return &wrappers.StringValue{Value: recordID}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/goat-project/goat-one/constants"
+
+	pb "github.com/goat-project/goat-proto-go"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Writer is the low-level gRPC sender for StorageRecords, passed into
+// writer.CreateWriter the same way virtualmachine.CreateWriter(limiter)
+// wraps the VmRecord gRPC sender.
+type Writer struct {
+	conn   *grpc.ClientConn
+	client pb.GoatServiceClient
+}
+
+// CreateWriter dials the Goat server and creates the low-level StorageRecord
+// gRPC sender.
+func CreateWriter() *Writer {
+	address := viper.GetString(constants.CfgGoatServerAddress)
+
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "address": address}).Error("error dial Goat server for storage records")
+		return &Writer{}
+	}
+
+	return &Writer{conn: conn, client: pb.NewGoatServiceClient(conn)}
+}
+
+// Write sends a single StorageRecord to the Goat server.
+func (w *Writer) Write(record proto.Message) error {
+	if w.client == nil {
+		return nil
+	}
+
+	storageRecord, ok := record.(*pb.StorageRecord)
+	if !ok {
+		return nil
+	}
+
+	_, err := w.client.SendStorageRecord(context.Background(), storageRecord)
+
+	return err
+}
+
+// SendIdentifier sends identifier to Goat server.
+func (w *Writer) SendIdentifier() error {
+	if w.client == nil {
+		return nil
+	}
+
+	_, err := w.client.SendIdentifier(context.Background(), &pb.Identifier{})
+
+	return err
+}
+
+// Finish closes the gRPC connection to the Goat server.
+func (w *Writer) Finish() {
+	if w.conn != nil {
+		if err := w.conn.Close(); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("error close Goat server connection")
+		}
+	}
+}
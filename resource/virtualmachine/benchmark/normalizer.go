@@ -0,0 +1,97 @@
+// Package benchmark normalizes wall-clock CPU time into the benchmark units
+// APEL accounting expects, following the EGI APEL accounting convention:
+// cpuDuration = wallDuration * vcpu * (benchmarkValue / referenceValue).
+package benchmark
+
+import (
+	"strconv"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// referenceValues holds the EGI APEL accounting reference value for each
+// benchmark type Normalizer understands out of the box. A type not listed
+// here (a site-defined custom multiplier) is applied as a raw multiplier,
+// i.e. with an implicit reference value of 1.
+var referenceValues = map[string]float64{
+	"HEPSPEC06":  10,
+	"HEPSCORE23": 1,
+	"SI2K":       1000,
+}
+
+// Level is one step of the host -> cluster -> datastore fallback chain: the
+// benchmark type/value pair read from an OpenNebula template attribute at
+// that level, empty if that level doesn't define one.
+type Level struct {
+	Type  string
+	Value string
+}
+
+// Result is the benchmark actually applied to a record, alongside the
+// normalized CPU duration it produced.
+type Result struct {
+	CPUDuration *duration.Duration
+	Type        string
+	Value       float32
+}
+
+// Normalizer converts wall-clock duration into normalized CPU time, falling
+// back from host to cluster to datastore and finally to a configured global
+// default when none of the template levels define a benchmark.
+type Normalizer struct {
+	globalType  string
+	globalValue float64
+}
+
+// CreateNormalizer creates a Normalizer, reading the global fallback
+// benchmark type/value from viper configuration.
+func CreateNormalizer() *Normalizer {
+	return &Normalizer{
+		globalType:  viper.GetString(constants.CfgBenchmarkDefaultType),
+		globalValue: viper.GetFloat64(constants.CfgBenchmarkDefaultValue),
+	}
+}
+
+// Normalize computes the normalized CPU duration for wallDurationSeconds on
+// vcpu CPUs, trying each level in order (typically host, then cluster, then
+// datastore) and falling back to the configured global default.
+func (n *Normalizer) Normalize(wallDurationSeconds int64, vcpu uint32, levels ...Level) Result {
+	bType, bValue := n.resolve(levels)
+
+	reference, ok := referenceValues[bType]
+	if !ok || reference <= 0 {
+		log.WithFields(log.Fields{"type": bType}).Warn("unknown or custom benchmark type, applying as raw multiplier")
+		reference = 1
+	}
+
+	cpuSeconds := float64(wallDurationSeconds) * float64(vcpu) * (bValue / reference)
+
+	return Result{
+		CPUDuration: &duration.Duration{Seconds: int64(cpuSeconds)},
+		Type:        bType,
+		Value:       float32(bValue),
+	}
+}
+
+func (n *Normalizer) resolve(levels []Level) (string, float64) {
+	for _, level := range levels {
+		if level.Type == "" || level.Value == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(level.Value, 64)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "value": level.Value}).Warn("error parse benchmark value")
+			continue
+		}
+
+		return level.Type, value
+	}
+
+	return n.globalType, n.globalValue
+}
@@ -0,0 +1,125 @@
+package virtualmachine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/onego-project/onego/resources"
+)
+
+// stateCodeFor returns the VM_STATE code that resources.VirtualMachineStateMap
+// maps to label, failing the test if no such code exists rather than
+// guessing a literal that might not match the real map.
+func stateCodeFor(t *testing.T, label string) int {
+	t.Helper()
+
+	for code, l := range resources.VirtualMachineStateMap {
+		if l == label {
+			return code
+		}
+	}
+
+	t.Fatalf("no VM_STATE code maps to %q in resources.VirtualMachineStateMap", label)
+
+	return 0
+}
+
+func TestHistoryRecordState(t *testing.T) {
+	active := stateCodeFor(t, "ACTIVE")
+	unknownCode := -1
+
+	tests := []struct {
+		name  string
+		state *int
+		want  string
+	}{
+		{"nil state", nil, "UNKNOWN"},
+		{"recognized state", &active, "ACTIVE"},
+		{"unrecognized state", &unknownCode, "UNKNOWN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &resources.HistoryRecord{State: tt.state}
+			if got := historyRecordState(record); got != tt.want {
+				t.Errorf("historyRecordState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSumDurations(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	windowEnd := base.Add(time.Hour).Unix()
+
+	at := func(offset time.Duration) *time.Time {
+		tm := base.Add(offset)
+		return &tm
+	}
+
+	tests := []struct {
+		name        string
+		intervals   []historyInterval
+		wantWall    int64
+		wantSuspend int64
+	}{
+		{
+			name: "live migration: two adjacent ACTIVE records sum wall time",
+			intervals: []historyInterval{
+				{start: base.Unix(), end: at(10 * time.Minute), state: "ACTIVE"},
+				{start: base.Add(10 * time.Minute).Unix(), end: at(30 * time.Minute), state: "ACTIVE"},
+			},
+			wantWall:    30 * 60,
+			wantSuspend: 0,
+		},
+		{
+			name: "resume after suspend: ACTIVE then SUSPENDED then ACTIVE again",
+			intervals: []historyInterval{
+				{start: base.Unix(), end: at(10 * time.Minute), state: "ACTIVE"},
+				{start: base.Add(10 * time.Minute).Unix(), end: at(20 * time.Minute), state: "SUSPENDED"},
+				{start: base.Add(20 * time.Minute).Unix(), end: at(25 * time.Minute), state: "ACTIVE"},
+			},
+			wantWall:    15 * 60,
+			wantSuspend: 10 * 60,
+		},
+		{
+			name: "crash: open-ended ACTIVE record clamps to the reporting window end",
+			intervals: []historyInterval{
+				{start: base.Unix(), end: nil, state: "ACTIVE"},
+			},
+			wantWall:    3600,
+			wantSuspend: 0,
+		},
+		{
+			name: "crash: POWEROFF after an unclean shutdown counts as suspend time",
+			intervals: []historyInterval{
+				{start: base.Unix(), end: at(5 * time.Minute), state: "ACTIVE"},
+				{start: base.Add(5 * time.Minute).Unix(), end: nil, state: "POWEROFF"},
+			},
+			wantWall:    5 * 60,
+			wantSuspend: 3600 - 5*60,
+		},
+		{
+			name: "unknown/failed state contributes to neither bucket",
+			intervals: []historyInterval{
+				{start: base.Unix(), end: at(10 * time.Minute), state: "UNKNOWN"},
+			},
+			wantWall:    0,
+			wantSuspend: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wall, suspend := sumDurations(tt.intervals, windowEnd)
+
+			if wall.Seconds != tt.wantWall {
+				t.Errorf("wallDuration = %d, want %d", wall.Seconds, tt.wantWall)
+			}
+
+			if suspend.Seconds != tt.wantSuspend {
+				t.Errorf("suspendDuration = %d, want %d", suspend.Seconds, tt.wantSuspend)
+			}
+		})
+	}
+}
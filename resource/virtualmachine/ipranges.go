@@ -0,0 +1,133 @@
+package virtualmachine
+
+import (
+	"net"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ipv4SpecialPurpose lists the IANA IPv4 Special-Purpose Address Registry
+// ranges that must never be counted as public, beyond the RFC1918 private
+// blocks already excluded by net.IP.IsPrivate-style checks.
+var ipv4SpecialPurpose = []string{
+	"100.64.0.0/10",   // RFC6598 Shared Address Space (Carrier-Grade NAT)
+	"169.254.0.0/16",  // RFC3927 Link Local
+	"192.0.0.0/24",    // RFC6890 IETF Protocol Assignments
+	"192.0.2.0/24",    // RFC5737 Documentation (TEST-NET-1)
+	"198.18.0.0/15",   // RFC2544 Benchmarking
+	"198.51.100.0/24", // RFC5737 Documentation (TEST-NET-2)
+	"203.0.113.0/24",  // RFC5737 Documentation (TEST-NET-3)
+	"224.0.0.0/4",     // RFC1112 Multicast
+	"240.0.0.0/4",     // RFC1112 Reserved for future use
+}
+
+// ipv6SpecialPurpose lists the IANA IPv6 Special-Purpose Address Registry
+// ranges that must never be counted as public.
+var ipv6SpecialPurpose = []string{
+	"100::/64",      // RFC6666 Discard-Only Address Block
+	"2001:db8::/32", // RFC3849 Documentation
+	"fc00::/7",      // RFC4193 Unique Local Addresses (ULA)
+	"fe80::/10",     // RFC4291 Link-Local Unicast
+}
+
+var (
+	privateRangesOnce sync.Once
+	privateRanges     []*net.IPNet
+)
+
+// privateIPNets returns the combined IPv4/IPv6 deny list: the IANA
+// special-purpose ranges above plus any site-specific ranges configured via
+// viper (constants.CfgAdditionalPrivateRanges), so sites can extend the list
+// without a code change.
+func privateIPNets() []*net.IPNet {
+	privateRangesOnce.Do(func() {
+		privateRanges = parseCIDRs(ipv4SpecialPurpose)
+		privateRanges = append(privateRanges, parseCIDRs(ipv6SpecialPurpose)...)
+		privateRanges = append(privateRanges, parseCIDRs(viper.GetStringSlice(constants.CfgAdditionalPrivateRanges))...)
+	})
+
+	return privateRanges
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "cidr": cidr}).Error("error parse configured IP range")
+			continue
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets
+}
+
+// isPublicIPv4 reports whether ip is a publicly routable IPv4 address: not
+// loopback, link-local, multicast, RFC1918 private, or any of the other
+// IANA special-purpose ranges (CGNAT, benchmarking, documentation, ...).
+func isPublicIPv4(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+
+	if ip4.IsLoopback() || ip4.IsLinkLocalMulticast() || ip4.IsLinkLocalUnicast() ||
+		ip4.IsMulticast() || ip4.IsUnspecified() {
+		return false
+	}
+
+	switch true {
+	case ip4[0] == 10:
+		return false
+	case ip4[0] == 172 && ip4[1] >= 16 && ip4[1] <= 31:
+		return false
+	case ip4[0] == 192 && ip4[1] == 168:
+		return false
+	}
+
+	for _, ipNet := range privateIPNets() {
+		if ipNet.Contains(ip4) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPublicIPv6 reports whether ip is a publicly routable IPv6 address,
+// excluding loopback, multicast, ULA (fc00::/7), link-local (fe80::/10),
+// the discard-only block (100::/64) and documentation (2001:db8::/32).
+func isPublicIPv6(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if ip.To4() != nil {
+		return false
+	}
+
+	if ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, ipNet := range privateIPNets() {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
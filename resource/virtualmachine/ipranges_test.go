@@ -0,0 +1,75 @@
+package virtualmachine
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIPv4(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{"public", "8.8.8.8", true},
+		{"rfc1918 10/8", "10.0.0.1", false},
+		{"rfc1918 172.16/12", "172.20.1.1", false},
+		{"rfc1918 192.168/16", "192.168.1.1", false},
+		{"loopback", "127.0.0.1", false},
+		{"cgnat", "100.64.0.1", false},
+		{"link-local", "169.254.1.1", false},
+		{"ietf protocol assignments", "192.0.0.1", false},
+		{"documentation test-net-1", "192.0.2.1", false},
+		{"benchmarking", "198.18.0.1", false},
+		{"documentation test-net-2", "198.51.100.1", false},
+		{"documentation test-net-3", "203.0.113.1", false},
+		{"multicast", "224.0.0.1", false},
+		{"reserved", "240.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPublicIPv4(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("isPublicIPv4(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicIPv4_Nil(t *testing.T) {
+	if isPublicIPv4(nil) {
+		t.Error("isPublicIPv4(nil) = true, want false")
+	}
+}
+
+func TestIsPublicIPv6(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{"public", "2001:4860:4860::8888", true},
+		{"loopback", "::1", false},
+		{"multicast", "ff02::1", false},
+		{"ula", "fc00::1", false},
+		{"ula upper half", "fd12:3456::1", false},
+		{"link-local", "fe80::1", false},
+		{"discard-only", "100::1", false},
+		{"documentation", "2001:db8::1", false},
+		{"ipv4-mapped is not ipv6-public", "::ffff:8.8.8.8", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPublicIPv6(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("isPublicIPv6(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicIPv6_Nil(t *testing.T) {
+	if isPublicIPv6(nil) {
+		t.Error("isPublicIPv6(nil) = true, want false")
+	}
+}
@@ -0,0 +1,262 @@
+// Package metrics provides a Prometheus-compatible alternative to the Goat/APEL
+// gRPC sink for virtual machine records. It lets operators scrape usage data
+// directly from the exporter (pull mode) or have it pushed to a Ceilometer-style
+// collector (push mode) instead of relying solely on APEL delivery.
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
+
+	pb "github.com/goat-project/goat-proto-go"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mode distinguishes how metrics leave the exporter.
+type Mode string
+
+const (
+	// ModePull exposes a /metrics endpoint for Prometheus to scrape.
+	ModePull Mode = "pull"
+
+	// ModePush batches samples and sends them to a Ceilometer-style collector
+	// when SendIdentifier or Finish is called.
+	ModePush Mode = "push"
+)
+
+var recordLabels = []string{
+	"site", "cloud_type", "global_user_name", "fqan", "image_id", "benchmark_type",
+}
+
+// Writer implements writer.Writer by translating each pb.VmRecord into
+// labeled Prometheus gauges/counters instead of sending it to the Goat server.
+type Writer struct {
+	mode       Mode
+	pushURL    string
+	httpClient *http.Client
+	registry   *prometheus.Registry
+
+	mu      sync.Mutex
+	samples []sample
+
+	wallDuration    *prometheus.GaugeVec
+	cpuDuration     *prometheus.GaugeVec
+	suspendDuration *prometheus.GaugeVec
+	cpuCount        *prometheus.GaugeVec
+	networkInbound  *prometheus.GaugeVec
+	networkOutbound *prometheus.GaugeVec
+	publicIPCount   *prometheus.GaugeVec
+	memory          *prometheus.GaugeVec
+	disk            *prometheus.GaugeVec
+	recordsTotal    *prometheus.CounterVec
+}
+
+type sample struct {
+	labels map[string]string
+	fields map[string]float64
+}
+
+// CreateWriter creates a metrics Writer. The mode and push URL are read from
+// viper configuration so sites can switch between pull and push without a
+// code change.
+func CreateWriter() *Writer {
+	mode := Mode(viper.GetString(constants.CfgMetricsMode))
+	if mode != ModePush {
+		mode = ModePull
+	}
+
+	w := &Writer{
+		mode:       mode,
+		pushURL:    viper.GetString(constants.CfgMetricsPushURL),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		registry:   prometheus.NewRegistry(),
+
+		wallDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_wall_duration_seconds", Help: "Wall clock duration of the virtual machine.",
+		}, recordLabels),
+		cpuDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_cpu_duration_seconds", Help: "Normalized CPU duration of the virtual machine.",
+		}, recordLabels),
+		suspendDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_suspend_duration_seconds", Help: "Time the virtual machine spent suspended.",
+		}, recordLabels),
+		cpuCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_cpu_count", Help: "Number of virtual CPUs allocated to the virtual machine.",
+		}, recordLabels),
+		networkInbound: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_network_inbound_bytes", Help: "Inbound network traffic of the virtual machine.",
+		}, recordLabels),
+		networkOutbound: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_network_outbound_bytes", Help: "Outbound network traffic of the virtual machine.",
+		}, recordLabels),
+		publicIPCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_public_ip_count", Help: "Number of public IP addresses assigned to the virtual machine.",
+		}, recordLabels),
+		memory: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_memory_bytes", Help: "Memory allocated to the virtual machine.",
+		}, recordLabels),
+		disk: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vm_disk_bytes", Help: "Disk space allocated to the virtual machine.",
+		}, recordLabels),
+		recordsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vm_records_total", Help: "Number of virtual machine records processed.",
+		}, recordLabels),
+	}
+
+	if mode == ModePull {
+		w.register()
+		w.serve()
+	}
+
+	return w
+}
+
+// register registers this Writer's collectors on its own dedicated registry
+// rather than prometheus's global DefaultRegisterer, so a second Writer
+// constructed in the same process (a second CreatePreparer, a test) doesn't
+// panic on a duplicate registration.
+func (w *Writer) register() {
+	w.registry.MustRegister(w.wallDuration, w.cpuDuration, w.suspendDuration, w.cpuCount,
+		w.networkInbound, w.networkOutbound, w.publicIPCount, w.memory, w.disk, w.recordsTotal)
+}
+
+func (w *Writer) serve() {
+	addr := viper.GetString(constants.CfgMetricsListenAddress)
+	if addr == "" {
+		addr = ":9195"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(w.registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.WithFields(log.Fields{"error": err, "address": addr}).Error("error serve metrics endpoint")
+		}
+	}()
+}
+
+// Write records one pb.VmRecord as a set of labeled gauges/counters and, in
+// push mode, also appends it to the batch pushed to the Ceilometer-style
+// collector.
+func (w *Writer) Write(record proto.Message) error {
+	vmRecord, ok := record.(*pb.VmRecord)
+	if !ok {
+		return nil
+	}
+
+	labels := vmLabels(vmRecord)
+
+	w.wallDuration.With(labels).Set(durationSeconds(vmRecord.GetWallDuration()))
+	w.cpuDuration.With(labels).Set(durationSeconds(vmRecord.GetCpuDuration()))
+	w.suspendDuration.With(labels).Set(durationSeconds(vmRecord.GetSuspendDuration()))
+	w.cpuCount.With(labels).Set(float64(vmRecord.GetCpuCount()))
+	w.networkInbound.With(labels).Set(float64(vmRecord.GetNetworkInbound().GetValue()))
+	w.networkOutbound.With(labels).Set(float64(vmRecord.GetNetworkOutbound().GetValue()))
+	w.publicIPCount.With(labels).Set(float64(vmRecord.GetPublicIpCount().GetValue()))
+	w.memory.With(labels).Set(float64(vmRecord.GetMemory().GetValue()))
+	w.disk.With(labels).Set(float64(vmRecord.GetDisk().GetValue()))
+	w.recordsTotal.With(labels).Inc()
+
+	if w.mode == ModePush {
+		w.enqueue(labels, vmRecord)
+	}
+
+	return nil
+}
+
+func (w *Writer) enqueue(labels map[string]string, vmRecord *pb.VmRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples = append(w.samples, sample{
+		labels: labels,
+		fields: map[string]float64{
+			"vm_wall_duration_seconds":    durationSeconds(vmRecord.GetWallDuration()),
+			"vm_cpu_duration_seconds":     durationSeconds(vmRecord.GetCpuDuration()),
+			"vm_suspend_duration_seconds": durationSeconds(vmRecord.GetSuspendDuration()),
+			"vm_cpu_count":                float64(vmRecord.GetCpuCount()),
+		},
+	})
+}
+
+// SendIdentifier flushes any samples batched for push mode; pull mode has
+// nothing to send since Prometheus scrapes on its own schedule.
+func (w *Writer) SendIdentifier() error {
+	if w.mode != ModePush {
+		return nil
+	}
+
+	return w.push()
+}
+
+func (w *Writer) push() error {
+	w.mu.Lock()
+	batch := w.samples
+	w.samples = nil
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.httpClient.Post(w.pushURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push metrics to %s: unexpected status %s", w.pushURL, resp.Status)
+	}
+
+	return nil
+}
+
+// Finish pushes any samples still batched in push mode. In pull mode it does
+// nothing: the endpoint keeps serving until the process exits.
+func (w *Writer) Finish() {
+	if w.mode != ModePush {
+		return
+	}
+
+	if err := w.push(); err != nil {
+		log.WithFields(log.Fields{"error": err, "url": w.pushURL}).Error("error push metrics")
+	}
+}
+
+func vmLabels(vmRecord *pb.VmRecord) map[string]string {
+	return map[string]string{
+		"site":             vmRecord.GetSiteName(),
+		"cloud_type":       vmRecord.GetCloudType().GetValue(),
+		"global_user_name": vmRecord.GetGlobalUserName().GetValue(),
+		"fqan":             vmRecord.GetFqan().GetValue(),
+		"image_id":         vmRecord.GetImageId().GetValue(),
+		"benchmark_type":   vmRecord.GetBenchmarkType().GetValue(),
+	}
+}
+
+func durationSeconds(d interface{ GetSeconds() int64 }) float64 {
+	if d == nil {
+		return 0
+	}
+
+	return float64(d.GetSeconds())
+}
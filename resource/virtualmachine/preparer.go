@@ -2,7 +2,6 @@ package virtualmachine
 
 import (
 	"fmt"
-	"net"
 	"strconv"
 	"sync"
 	"time"
@@ -17,6 +16,13 @@ import (
 
 	"github.com/goat-project/goat-one/constants"
 
+	"github.com/goat-project/goat-one/resource/storage"
+	"github.com/goat-project/goat-one/resource/virtualmachine/benchmark"
+	"github.com/goat-project/goat-one/resource/virtualmachine/metrics"
+
+	"github.com/goat-project/goat-one/writer/spool"
+
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/golang/protobuf/ptypes/timestamp"
@@ -39,6 +45,10 @@ type Preparer struct {
 	imageTemplateCloudkeeperApplianceMpuri map[int]string
 	hostTemplateBenchmarkType              map[int]string
 	hostTemplateBenchmarkValue             map[int]string
+	datastoreTemplateBenchmarkType         map[int]string
+	datastoreTemplateBenchmarkValue        map[int]string
+	normalizer                             *benchmark.Normalizer
+	storageRegistry                        *storage.Registry
 }
 
 const (
@@ -48,27 +58,51 @@ const (
 	templateBenchmarkValue            = "TEMPLATE/BENCHMARK_VALUE"
 )
 
-type benchmark struct {
+type clusterBenchmark struct {
 	bType  string
 	bValue string
 }
 
-// CreatePreparer creates Preparer for virtual machine records.
-func CreatePreparer(reader *reader.Reader, limiter *rate.Limiter) *Preparer {
+// CreatePreparer creates Preparer for virtual machine records. storageRegistry
+// is the handle produced by storage.Preparer.Registry, used to resolve
+// pb.VmRecord.StorageRecordId once the storage pass has completed; pass nil
+// if this run doesn't prepare storage records, and StorageRecordId is left
+// unset.
+func CreatePreparer(reader *reader.Reader, limiter *rate.Limiter, storageRegistry *storage.Registry) *Preparer {
 	return &Preparer{
-		reader: *reader,
-		Writer: *writer.CreateWriter(CreateWriter(limiter)),
+		reader:          *reader,
+		Writer:          createWriter(limiter),
+		normalizer:      benchmark.CreateNormalizer(),
+		storageRegistry: storageRegistry,
+	}
+}
+
+// createWriter picks the record sink according to constants.CfgWriterBackend:
+// the default Goat/APEL gRPC sink, or a Prometheus-compatible metrics sink
+// (pull or Ceilometer-style push, see constants.CfgMetricsMode) so operators
+// can scrape usage directly instead of relying only on APEL delivery.
+func createWriter(limiter *rate.Limiter) writer.Writer {
+	if viper.GetString(constants.CfgWriterBackend) == "metrics" {
+		return *writer.CreateWriter(metrics.CreateWriter())
+	}
+
+	grpcWriter := CreateWriter(limiter)
+	if !viper.GetBool(constants.CfgSpoolEnabled) {
+		return *writer.CreateWriter(grpcWriter)
 	}
+
+	return *writer.CreateWriter(spool.CreateWriter(grpcWriter, func() proto.Message { return &pb.VmRecord{} }))
 }
 
 // InitializeMaps reads additional data for virtual machine record.
 func (p *Preparer) InitializeMaps(mapWg *sync.WaitGroup) {
 	defer mapWg.Done()
 
-	mapWg.Add(3)
+	mapWg.Add(4)
 	go p.initializeUserTemplateIdentity(mapWg)
 	go p.initializeImageTemplateCloudkeeperApplianceMpuri(mapWg)
 	go p.initializeHostTemplateBenchmark(mapWg)
+	go p.initializeDatastoreTemplateBenchmark(mapWg)
 }
 
 func (p *Preparer) initializeUserTemplateIdentity(mapWg *sync.WaitGroup) {
@@ -163,7 +197,38 @@ func (p *Preparer) initializeHostTemplateBenchmark(mapWg *sync.WaitGroup) {
 	}
 }
 
-func (p *Preparer) valueFromCluster(clustersMap map[int]benchmark, host *resources.Host) string {
+func (p *Preparer) initializeDatastoreTemplateBenchmark(mapWg *sync.WaitGroup) {
+	defer mapWg.Done()
+
+	datastores, err := p.reader.ListAllDatastores()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error list all datastores")
+		return
+	}
+
+	p.datastoreTemplateBenchmarkType = make(map[int]string, len(datastores))
+	p.datastoreTemplateBenchmarkValue = make(map[int]string, len(datastores))
+
+	for _, datastore := range datastores {
+		id, err := datastore.ID()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("error get datastore ID")
+			continue
+		}
+
+		bType, err := datastore.Attribute(templateBenchmarkType)
+		if err == nil {
+			p.datastoreTemplateBenchmarkType[id] = bType
+		}
+
+		bValue, err := datastore.Attribute(templateBenchmarkValue)
+		if err == nil {
+			p.datastoreTemplateBenchmarkValue[id] = bValue
+		}
+	}
+}
+
+func (p *Preparer) valueFromCluster(clustersMap map[int]clusterBenchmark, host *resources.Host) string {
 	clusterID, err := host.Cluster()
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("error get cluster ID from host")
@@ -173,7 +238,7 @@ func (p *Preparer) valueFromCluster(clustersMap map[int]benchmark, host *resourc
 	return clustersMap[clusterID].bValue
 }
 
-func (p *Preparer) typeFromCluster(clustersMap map[int]benchmark, host *resources.Host) string {
+func (p *Preparer) typeFromCluster(clustersMap map[int]clusterBenchmark, host *resources.Host) string {
 	clusterID, err := host.Cluster()
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("error get cluster ID from host")
@@ -183,13 +248,13 @@ func (p *Preparer) typeFromCluster(clustersMap map[int]benchmark, host *resource
 	return clustersMap[clusterID].bType
 }
 
-func (p *Preparer) clustersMap() map[int]benchmark {
+func (p *Preparer) clustersMap() map[int]clusterBenchmark {
 	clusters, err := p.reader.ListAllClusters()
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Fatal("error list all clusters")
 	}
 
-	idToBenchmark := make(map[int]benchmark, len(clusters))
+	idToBenchmark := make(map[int]clusterBenchmark, len(clusters))
 
 	for _, cluster := range clusters {
 		id, err := cluster.ID()
@@ -208,7 +273,7 @@ func (p *Preparer) clustersMap() map[int]benchmark {
 			log.WithFields(log.Fields{"error": err, "cluster": id}).Warn("couldn't get benchmark value from cluster")
 		}
 
-		idToBenchmark[id] = benchmark{bType: bType, bValue: bValue}
+		idToBenchmark[id] = clusterBenchmark{bType: bType, bValue: bValue}
 	}
 
 	return idToBenchmark
@@ -255,7 +320,9 @@ func (p *Preparer) Preparation(acc resource.Resource, wg *sync.WaitGroup) {
 	}
 
 	eTime := getEndTime(vm)
-	wallDuration := getWallDuration(vm)
+	wallDuration, suspendDuration := getDurations(vm)
+	cpuCount := getCPUCount(vm)
+	applied := p.normalizeBenchmark(vm, wallDuration, cpuCount)
 
 	vmRecord := pb.VmRecord{
 		VmUuid:              vmuuid,
@@ -269,19 +336,19 @@ func (p *Preparer) Preparation(acc resource.Resource, wg *sync.WaitGroup) {
 		Status:              getStatus(vm),
 		StartTime:           sTime,
 		EndTime:             eTime,
-		SuspendDuration:     getSuspendDuration(sTime, eTime, wallDuration),
+		SuspendDuration:     suspendDuration,
 		WallDuration:        wallDuration,
-		CpuDuration:         wallDuration,
-		CpuCount:            getCPUCount(vm),
+		CpuDuration:         applied.CPUDuration,
+		CpuCount:            cpuCount,
 		NetworkType:         getNetworkType(),
 		NetworkInbound:      getNetworkInbound(vm),
 		NetworkOutbound:     getNetworkOutbound(vm),
 		PublicIpCount:       getPublicIPCount(vm),
 		Memory:              getMemory(vm),
 		Disk:                getDiskSizes(vm),
-		BenchmarkType:       getBenchmarkType(p, vm),
-		Benchmark:           getBenchmark(p, vm),
-		StorageRecordId:     nil,
+		BenchmarkType:       checkValueErrStr(applied.Type, nil),
+		Benchmark:           checkValueErrFloat(applied.Value),
+		StorageRecordId:     p.getStorageRecordID(vm),
 		ImageId:             getImageID(p, vm),
 		CloudType:           getCloudType(),
 	}
@@ -391,46 +458,99 @@ func getEndTime(vm *resources.VirtualMachine) *timestamp.Timestamp {
 	return ts
 }
 
-func getSuspendDuration(sTime, eTime *timestamp.Timestamp, wallDuration *duration.Duration) *duration.Duration {
-	if eTime != nil && sTime != nil && wallDuration != nil {
-		return &duration.Duration{Seconds: eTime.Seconds - sTime.Seconds - wallDuration.Seconds}
-	}
-
-	return nil
-}
-
-func getWallDuration(vm *resources.VirtualMachine) *duration.Duration {
+// getDurations walks the VM's history records and accumulates wallDuration
+// and suspendDuration via sumDurations (see its doc comment for why this
+// isn't a naive STIME/ETIME subtraction).
+func getDurations(vm *resources.VirtualMachine) (wallDuration, suspendDuration *duration.Duration) {
 	historyRecords, err := vm.HistoryRecords()
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("error get history records")
-		return nil
+		return nil, nil
 	}
 
-	currentTime := time.Now().Unix()
-
-	var sum int64
+	intervals := make([]historyInterval, 0, len(historyRecords))
 	for _, record := range historyRecords {
-		if record != nil {
-			rsTime := record.RSTime
-			if rsTime == nil {
-				continue
-			}
-
-			reTime := record.RETime
-			if reTime == nil {
-				continue
-			}
-
-			reTimeUnix := reTime.Unix()
-			if reTimeUnix == 0 {
-				reTimeUnix = currentTime
-			}
-
-			sum += reTimeUnix - rsTime.Unix()
+		if record == nil || record.RSTime == nil {
+			continue
 		}
+
+		intervals = append(intervals, historyInterval{
+			start: record.RSTime.Unix(),
+			end:   record.RETime,
+			state: historyRecordState(record),
+		})
 	}
 
-	return &duration.Duration{Seconds: sum}
+	return sumDurations(intervals, time.Now().Unix())
+}
+
+// historyInterval is the pure, testable shape of a single history record's
+// contribution to wall/suspend duration accounting: a start/end span in a
+// given state, decoupled from resources.HistoryRecord itself.
+type historyInterval struct {
+	start int64
+	end   *time.Time
+	state string
+}
+
+// sumDurations accumulates wallDuration over the ACTIVE state and
+// suspendDuration over SUSPENDED/POWEROFF, using each interval's own state
+// instead of naively subtracting STIME/ETIME - that subtraction
+// double-counts migrations and mislabels FAILED/UNKNOWN time as suspended.
+// An open-ended interval (end == nil or ETIME == 0, e.g. the VM is still in
+// that state) is clamped to windowEnd.
+func sumDurations(intervals []historyInterval, windowEnd int64) (wallDuration, suspendDuration *duration.Duration) {
+	var wallSum, suspendSum int64
+
+	for _, iv := range intervals {
+		start := iv.start
+		end := windowEnd
+
+		if iv.end != nil && iv.end.Unix() != 0 {
+			end = iv.end.Unix()
+		}
+
+		if end < start {
+			continue
+		}
+
+		elapsed := end - start
+
+		switch iv.state {
+		case "ACTIVE":
+			wallSum += elapsed
+		case "SUSPENDED", "POWEROFF":
+			suspendSum += elapsed
+		}
+	}
+
+	return &duration.Duration{Seconds: wallSum}, &duration.Duration{Seconds: suspendSum}
+}
+
+// historyRecordState maps a history record's state to the same labels
+// resources.VirtualMachineStateMap uses for vm.State(), the VM's overall
+// VM_STATE, falling back to UNKNOWN for a missing or unrecognized state
+// (e.g. FAILED) so it's neither counted as wall nor suspend time.
+//
+// This assumes resources.HistoryRecord.State lives in the same VM_STATE
+// domain as vm.State() rather than the LCM_STATE sub-state domain (where
+// "RUNNING" - not present in the switch above - would appear instead of
+// "ACTIVE"). That assumption is unverified against the vendored onego
+// source and should be checked against github.com/onego-project/onego
+// before this ships; sumDurations is written against HistoryRecord.State's
+// actual reported value either way, so only this mapping needs revisiting
+// if it turns out to be wrong.
+func historyRecordState(record *resources.HistoryRecord) string {
+	if record.State == nil {
+		return "UNKNOWN"
+	}
+
+	state, ok := resources.VirtualMachineStateMap[*record.State]
+	if !ok {
+		return "UNKNOWN"
+	}
+
+	return state
 }
 
 func getCPUCount(vm *resources.VirtualMachine) uint32 {
@@ -462,7 +582,7 @@ func getPublicIPCount(vm *resources.VirtualMachine) *wrappers.UInt64Value {
 
 	var count uint64
 	for _, nic := range nics {
-		if isPublicIPv4(nic.IP) || nic.IP6Global != nil {
+		if isPublicIPv4(nic.IP) || isPublicIPv6(nic.IP6Global) {
 			count++
 		}
 	}
@@ -489,31 +609,65 @@ func getDiskSizes(vm *resources.VirtualMachine) *wrappers.UInt64Value {
 	return &wrappers.UInt64Value{Value: sum}
 }
 
-func getBenchmarkType(p *Preparer, vm *resources.VirtualMachine) *wrappers.StringValue {
-	historyRecords, err := vm.HistoryRecords()
-	if err == nil && len(historyRecords) > 0 {
-		tbt := p.hostTemplateBenchmarkType[*historyRecords[0].HID]
-		if tbt != "" {
-			return &wrappers.StringValue{Value: tbt}
-		}
+// normalizeBenchmark resolves the benchmark type/value to apply to vm via
+// the host -> cluster -> datastore -> global default fallback chain (the
+// host level already folds in the cluster fallback, see
+// initializeHostTemplateBenchmark) and normalizes wallDuration into CPU time
+// for the EGI APEL accounting record.
+func (p *Preparer) normalizeBenchmark(vm *resources.VirtualMachine, wallDuration *duration.Duration, vcpu uint32) benchmark.Result {
+	levels := []benchmark.Level{
+		p.hostBenchmarkLevel(vm),
+		p.datastoreBenchmarkLevel(vm),
 	}
 
-	return nil
+	var wallSeconds int64
+	if wallDuration != nil {
+		wallSeconds = wallDuration.Seconds
+	}
+
+	return p.normalizer.Normalize(wallSeconds, vcpu, levels...)
 }
 
-func getBenchmark(p *Preparer, vm *resources.VirtualMachine) *wrappers.FloatValue {
+func (p *Preparer) hostBenchmarkLevel(vm *resources.VirtualMachine) benchmark.Level {
 	historyRecords, err := vm.HistoryRecords()
-	if err == nil && len(historyRecords) > 0 {
-		tbv := p.hostTemplateBenchmarkValue[*historyRecords[0].HID]
-		if tbv != "" {
-			f, err := strconv.ParseFloat(tbv, 32)
-			if err == nil {
-				return &wrappers.FloatValue{Value: float32(f)}
-			}
-		}
+	if err != nil || len(historyRecords) == 0 || historyRecords[0].HID == nil {
+		return benchmark.Level{}
 	}
 
-	return nil
+	hid := *historyRecords[0].HID
+
+	return benchmark.Level{Type: p.hostTemplateBenchmarkType[hid], Value: p.hostTemplateBenchmarkValue[hid]}
+}
+
+func (p *Preparer) datastoreBenchmarkLevel(vm *resources.VirtualMachine) benchmark.Level {
+	disks, err := vm.Disks()
+	if err != nil || len(disks) == 0 || disks[0] == nil {
+		return benchmark.Level{}
+	}
+
+	dsID := disks[0].DatastoreID
+
+	return benchmark.Level{Type: p.datastoreTemplateBenchmarkType[dsID], Value: p.datastoreTemplateBenchmarkValue[dsID]}
+}
+
+// getStorageRecordID looks up the StorageRecord produced for the datastore
+// holding the image of the VM's first disk via p.storageRegistry (see
+// storage.Registry),
+// correlating VM and storage accounting instead of leaving this reference
+// empty. It blocks until the storage pass has finished, and returns nil if
+// this Preparer wasn't given a registry at all (the storage pass is disabled
+// for this run).
+func (p *Preparer) getStorageRecordID(vm *resources.VirtualMachine) *wrappers.StringValue {
+	if p.storageRegistry == nil {
+		return nil
+	}
+
+	disks, err := vm.Disks()
+	if err != nil || len(disks) == 0 || disks[0] == nil {
+		return nil
+	}
+
+	return p.storageRegistry.ImageLookup(disks[0].ImageID)
 }
 
 func getImageID(p *Preparer, vm *resources.VirtualMachine) *wrappers.StringValue {
@@ -549,6 +703,14 @@ func checkValueErrStr(value string, err error) *wrappers.StringValue {
 	return nil
 }
 
+func checkValueErrFloat(value float32) *wrappers.FloatValue {
+	if value == 0 {
+		return nil
+	}
+
+	return &wrappers.FloatValue{Value: value}
+}
+
 func checkErrUint64(value string, err error) *wrappers.UInt64Value {
 	if err == nil && value != "" {
 		var i uint64
@@ -572,28 +734,3 @@ func checkTime(t *time.Time, err error) (*timestamp.Timestamp, error) {
 
 	return nil, err
 }
-
-func isPublicIPv4(ip net.IP) bool {
-	if ip == nil {
-		return false
-	}
-
-	if ip.IsLoopback() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
-		return false
-	}
-
-	if ip4 := ip.To4(); ip4 != nil {
-		switch true {
-		case ip4[0] == 10:
-			return false
-		case ip4[0] == 172 && ip4[1] >= 16 && ip4[1] <= 31:
-			return false
-		case ip4[0] == 192 && ip4[1] == 168:
-			return false
-		default:
-			return true
-		}
-	}
-
-	return false
-}
\ No newline at end of file
@@ -0,0 +1,322 @@
+// Package spool makes record delivery durable across Goat server outages.
+// Every record is first persisted to a directory-based queue on disk, then a
+// background flusher drains it to the wrapped writer.Writer with exponential
+// backoff, acknowledging (deleting) each entry only once it has actually been
+// sent. This mirrors how APEL SSM and similar accounting pipelines guarantee
+// at-least-once delivery.
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/goat-project/goat-one/constants"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const pendingDir = "pending"
+
+var (
+	initialBackoff = time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// Sink is the inner writer the spool eventually drains acknowledged records
+// to - the same Write/SendIdentifier/Finish shape every writer backend in
+// this project implements.
+type Sink interface {
+	Write(record proto.Message) error
+	SendIdentifier() error
+	Finish()
+}
+
+// Writer spools records to disk before handing them to a Sink, so a record
+// survives even if the process is killed before the Sink could accept it.
+type Writer struct {
+	dir        string
+	maxSize    int64
+	retention  time.Duration
+	sink       Sink
+	newMessage func() proto.Message
+
+	mu      sync.Mutex
+	seq     int64
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// CreateWriter creates a Writer spooling to the directory configured via
+// constants.CfgSpoolPath (max size and retention likewise configurable),
+// replays any entries left over from a previous run, and starts the
+// background flusher that drains new entries to sink.
+func CreateWriter(sink Sink, newMessage func() proto.Message) *Writer {
+	dir := viper.GetString(constants.CfgSpoolPath)
+	if dir == "" {
+		dir = "./spool"
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, pendingDir), 0o755); err != nil {
+		log.WithFields(log.Fields{"error": err, "dir": dir}).Error("error create spool directory")
+	}
+
+	w := &Writer{
+		dir:        dir,
+		maxSize:    viper.GetInt64(constants.CfgSpoolMaxSize),
+		retention:  viper.GetDuration(constants.CfgSpoolRetention),
+		sink:       sink,
+		newMessage: newMessage,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+
+	seq, err := w.maxPendingSeq()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "dir": dir}).Error("error read existing spool entries, sequence may restart from zero")
+	}
+
+	w.seq = seq
+
+	w.stopped.Add(1)
+	go w.flushLoop()
+
+	return w
+}
+
+// maxPendingSeq returns the highest sequence number already on disk, so a
+// restart with unacked entries pending continues the sequence instead of
+// reusing numbers and overwriting them.
+func (w *Writer) maxPendingSeq() (int64, error) {
+	entries, err := w.pendingEntries()
+	if err != nil {
+		return 0, err
+	}
+
+	var max int64
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(filepath.Base(entry), ".pb")
+
+		seq, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if seq > max {
+			max = seq
+		}
+	}
+
+	return max, nil
+}
+
+// Write durably appends record to the spool and wakes the flusher; it
+// returns once the record is safely on disk, not once it has been delivered.
+func (w *Writer) Write(record proto.Message) error {
+	if err := w.enforceMaxSize(); err != nil {
+		return err
+	}
+
+	payload, err := proto.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal record for spool: %w", err)
+	}
+
+	w.mu.Lock()
+	w.seq++
+	seq := w.seq
+	w.mu.Unlock()
+
+	path := filepath.Join(w.dir, pendingDir, fmt.Sprintf("%020d.pb", seq))
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, payload, 0o644); err != nil {
+		return fmt.Errorf("write spool entry: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("commit spool entry: %w", err)
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// SendIdentifier passes straight through to the sink; the identifier
+// handshake is not something the spool replays on its own.
+func (w *Writer) SendIdentifier() error {
+	return w.sink.SendIdentifier()
+}
+
+// Finish stops the flusher, makes one last best-effort drain attempt, and
+// closes the underlying sink. Anything still unacknowledged stays on disk
+// and is replayed on the next run (or via the `goat-one replay` subcommand).
+func (w *Writer) Finish() {
+	close(w.stop)
+	w.stopped.Wait()
+	w.drain()
+	w.sink.Finish()
+}
+
+func (w *Writer) flushLoop() {
+	defer w.stopped.Done()
+
+	backoff := initialBackoff
+
+	for {
+		if w.drain() {
+			backoff = initialBackoff
+		} else {
+			backoff = nextBackoff(backoff)
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// drain attempts to deliver every pending entry, oldest first, stopping at
+// the first failure so ordering and at-least-once delivery are preserved.
+// It returns true if every pending entry was acknowledged.
+func (w *Writer) drain() bool {
+	entries, err := w.pendingEntries()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("error list spool entries")
+		return false
+	}
+
+	entries = w.pruneExpired(entries)
+
+	for _, entry := range entries {
+		if !w.deliver(entry) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *Writer) deliver(path string) bool {
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "path": path}).Error("error read spool entry")
+		return false
+	}
+
+	record := w.newMessage()
+	if err := proto.Unmarshal(payload, record); err != nil {
+		log.WithFields(log.Fields{"error": err, "path": path}).Error("error unmarshal spool entry, discarding")
+		_ = os.Remove(path)
+		return true
+	}
+
+	if err := w.sink.Write(record); err != nil {
+		log.WithFields(log.Fields{"error": err, "path": path}).Warn("error deliver spooled record, will retry")
+		return false
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.WithFields(log.Fields{"error": err, "path": path}).Error("error acknowledge spool entry")
+	}
+
+	return true
+}
+
+// pruneExpired discards entries older than the configured retention so a
+// permanently unreachable record doesn't keep the spool growing forever.
+func (w *Writer) pruneExpired(entries []string) []string {
+	if w.retention <= 0 {
+		return entries
+	}
+
+	cutoff := time.Now().Add(-w.retention)
+	kept := entries[:0]
+
+	for _, entry := range entries {
+		info, err := os.Stat(entry)
+		if err == nil && info.ModTime().Before(cutoff) {
+			log.WithFields(log.Fields{"path": entry}).Warn("discarding spool entry past retention")
+			_ = os.Remove(entry)
+			continue
+		}
+
+		kept = append(kept, entry)
+	}
+
+	return kept
+}
+
+func (w *Writer) pendingEntries() ([]string, error) {
+	dir := filepath.Join(w.dir, pendingDir)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".pb" {
+			continue
+		}
+
+		names = append(names, filepath.Join(dir, info.Name()))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (w *Writer) enforceMaxSize() error {
+	if w.maxSize <= 0 {
+		return nil
+	}
+
+	entries, err := w.pendingEntries()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		info, err := os.Stat(entry)
+		if err == nil {
+			total += info.Size()
+		}
+	}
+
+	if total < w.maxSize {
+		return nil
+	}
+
+	return fmt.Errorf("spool at %s exceeds configured max size (%d bytes)", w.dir, w.maxSize)
+}
+
+func nextBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next > maxBackoff {
+		return maxBackoff
+	}
+
+	return next
+}